@@ -0,0 +1,458 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"k8s.io/kubernetes/pkg/scheduler/metrics"
+	"k8s.io/kubernetes/pkg/scheduler/util"
+	"k8s.io/utils/clock"
+)
+
+// EvictionStrategy selects which entry unschedulablePods evicts when an
+// addOrUpdate would push it over Policy.MaxSize.
+type EvictionStrategy string
+
+const (
+	// EvictionReject refuses the incoming add/update instead of evicting
+	// an existing entry. It is the default when no strategy is set.
+	EvictionReject EvictionStrategy = "Reject"
+	// EvictionOldestFirst evicts the entry with the oldest LastAddedTimestamp.
+	EvictionOldestFirst EvictionStrategy = "OldestFirst"
+	// EvictionLeastRecentlyRequeued evicts the entry with the lowest
+	// RequeueCount, breaking ties by the oldest LastAddedTimestamp.
+	EvictionLeastRecentlyRequeued EvictionStrategy = "LeastRecentlyRequeued"
+)
+
+// EvictedPodsRecorder records forced removals from unschedulablePods,
+// labeled by the reason (EvictionStrategy) that triggered them. Under
+// EvictionReject it counts the incoming pods that were refused.
+type EvictedPodsRecorder interface {
+	Inc(reason string)
+}
+
+// Policy bounds the size of unschedulablePods and controls what happens
+// when an add/update would exceed that bound.
+type Policy struct {
+	// MaxSize is the maximum number of entries unschedulablePods may
+	// hold. Zero (the default) means unbounded.
+	MaxSize int
+	// EvictionStrategy selects which entry to remove when addOrUpdate is
+	// called at capacity. Defaults to EvictionReject.
+	EvictionStrategy EvictionStrategy
+	// OnEvict, if set, is called with the evicted pod's QueuedPodInfo and
+	// the eviction reason, so a scheduler extension (e.g. the reservation
+	// error-handler path) can observe forced removals and record them in
+	// its own metrics. Under EvictionReject it is called with the incoming
+	// pod that was refused instead.
+	OnEvict func(*framework.QueuedPodInfo, string)
+}
+
+// noopMetricRecorder is substituted for any of unschedulablePods' recorders
+// that are left nil, so call sites don't need their own nil checks.
+type noopMetricRecorder struct{}
+
+func (noopMetricRecorder) Inc()   {}
+func (noopMetricRecorder) Dec()   {}
+func (noopMetricRecorder) Clear() {}
+
+var _ metrics.MetricRecorder = noopMetricRecorder{}
+
+// unschedulablePods holds pods that cannot be successfully scheduled. They
+// are keyed by the pod's full name.
+type unschedulablePods struct {
+	// podInfoMap is a map key by a pod's full name and the value is a
+	// pointer to the QueuedPodInfo.
+	podInfoMap map[string]*framework.QueuedPodInfo
+	keyFunc    func(*v1.Pod) string
+	// unschedulableRecorder/gatedRecorder/reservationNominatedRecorder
+	// update the corresponding counter when an entry is added to or
+	// removed from podInfoMap.
+	unschedulableRecorder        metrics.MetricRecorder
+	gatedRecorder                metrics.MetricRecorder
+	reservationNominatedRecorder metrics.MetricRecorder
+	// reservationIndex maps a reservation name to the full names of the
+	// pods currently nominated against it. It lets a reservation plugin
+	// move every pod nominated against a reservation that just became
+	// Available straight to activeQ in O(k), instead of scanning the
+	// whole of podInfoMap.
+	reservationIndex map[string]sets.Set[string]
+	// pluginIndex maps a plugin name to the full names of the pods that
+	// are either gated by that plugin or were last found unschedulable
+	// due to it. It lets movePodsToActiveOrBackoffQueue dispatch only the
+	// pods relevant to a plugin's EventsToRegister firing, in O(k)
+	// instead of scanning the whole of podInfoMap.
+	pluginIndex map[string]sets.Set[string]
+	// policy bounds the size of podInfoMap and selects what happens when
+	// addOrUpdate is called at capacity.
+	policy Policy
+	// evictedRecorder counts forced removals, labeled by reason.
+	evictedRecorder EvictedPodsRecorder
+	// clock is used to stamp LastAddedTimestamp, so eviction ordering is
+	// deterministically testable instead of depending on real timer
+	// resolution between back-to-back addOrUpdate calls.
+	clock clock.Clock
+}
+
+// pluginNamesFor returns the set of plugin names pInfo should be indexed
+// under in pluginIndex: its GatingPlugin (if gated) plus every plugin in
+// UnschedulablePlugins.
+func pluginNamesFor(pInfo *framework.QueuedPodInfo) sets.Set[string] {
+	names := sets.New[string]()
+	if pInfo.Gated() {
+		names.Insert(pInfo.GatingPlugin)
+	}
+	for p := range pInfo.UnschedulablePlugins {
+		names.Insert(p)
+	}
+	return names
+}
+
+// recorderFor returns the metric recorder that accounts for pInfo's current
+// state: gated pods and reservation-nominated pods are tracked separately
+// from plain unschedulable pods.
+func (u *unschedulablePods) recorderFor(pInfo *framework.QueuedPodInfo) metrics.MetricRecorder {
+	var r metrics.MetricRecorder
+	switch {
+	case pInfo.Gated():
+		r = u.gatedRecorder
+	case pInfo.NominatedReservationName != "":
+		r = u.reservationNominatedRecorder
+	default:
+		r = u.unschedulableRecorder
+	}
+	if r == nil {
+		return noopMetricRecorder{}
+	}
+	return r
+}
+
+// addOrUpdate adds a pod to the unschedulable podInfoMap, or updates it if
+// it already exists. event is the event received that triggered this
+// addOrUpdate. If this is a new entry and podInfoMap is already at
+// Policy.MaxSize, the configured EvictionStrategy is applied first; if
+// that leaves no room (EvictionReject), the pod is not added.
+func (u *unschedulablePods) addOrUpdate(pInfo *framework.QueuedPodInfo, gatedBefore bool, event string) {
+	podID := u.keyFunc(pInfo.Pod)
+	oldPInfo, exists := u.podInfoMap[podID]
+	if !exists && u.policy.MaxSize > 0 && len(u.podInfoMap) >= u.policy.MaxSize {
+		if !u.makeRoom(pInfo) {
+			return
+		}
+	}
+	if exists {
+		pInfo.RequeueCount = oldPInfo.RequeueCount + 1
+	}
+	pInfo.LastAddedTimestamp = u.clock.Now()
+	if !exists {
+		u.recorderFor(pInfo).Inc()
+	} else if oldRecorder, newRecorder := u.recorderFor(oldPInfo), u.recorderFor(pInfo); oldRecorder != newRecorder {
+		oldRecorder.Dec()
+		newRecorder.Inc()
+	}
+	u.updateReservationIndex(podID, oldPInfo, pInfo)
+	u.updatePluginIndex(podID, oldPInfo, pInfo)
+	u.podInfoMap[podID] = pInfo
+}
+
+// delete removes a pod from the unschedulable podInfoMap.
+func (u *unschedulablePods) delete(pod *v1.Pod, gated bool) {
+	podID := u.keyFunc(pod)
+	if pInfo, exists := u.podInfoMap[podID]; exists {
+		u.removeEntry(podID, pInfo)
+	}
+}
+
+// removeEntry removes podID's entry from podInfoMap and every secondary
+// index, decrementing its metric recorder. Callers that are evicting an
+// entry (rather than processing a regular delete) must also call
+// recordEviction.
+func (u *unschedulablePods) removeEntry(podID string, pInfo *framework.QueuedPodInfo) {
+	u.recorderFor(pInfo).Dec()
+	if pInfo.NominatedReservationName != "" {
+		u.deleteFromReservationIndex(pInfo.NominatedReservationName, podID)
+	}
+	for name := range pluginNamesFor(pInfo) {
+		u.deleteFromPluginIndex(name, podID)
+	}
+	delete(u.podInfoMap, podID)
+}
+
+// makeRoom applies u.policy.EvictionStrategy to free capacity for
+// incoming. It returns whether incoming may now be added.
+func (u *unschedulablePods) makeRoom(incoming *framework.QueuedPodInfo) bool {
+	strategy := u.policy.EvictionStrategy
+	if strategy == "" {
+		strategy = EvictionReject
+	}
+	if strategy == EvictionReject {
+		u.recordEviction(incoming, string(EvictionReject))
+		return false
+	}
+
+	less := lessByOldest
+	if strategy == EvictionLeastRecentlyRequeued {
+		less = lessByRequeueCount
+	}
+	var victimID string
+	var victim *framework.QueuedPodInfo
+	for podID, pInfo := range u.podInfoMap {
+		if victim == nil || less(pInfo, victim) {
+			victimID, victim = podID, pInfo
+		}
+	}
+	if victim == nil {
+		return true
+	}
+	u.removeEntry(victimID, victim)
+	u.recordEviction(victim, string(strategy))
+	return true
+}
+
+func (u *unschedulablePods) recordEviction(pInfo *framework.QueuedPodInfo, reason string) {
+	if u.evictedRecorder != nil {
+		u.evictedRecorder.Inc(reason)
+	}
+	if u.policy.OnEvict != nil {
+		u.policy.OnEvict(pInfo, reason)
+	}
+}
+
+func lessByOldest(a, b *framework.QueuedPodInfo) bool {
+	return a.LastAddedTimestamp.Before(b.LastAddedTimestamp)
+}
+
+func lessByRequeueCount(a, b *framework.QueuedPodInfo) bool {
+	if a.RequeueCount != b.RequeueCount {
+		return a.RequeueCount < b.RequeueCount
+	}
+	return lessByOldest(a, b)
+}
+
+// get returns the QueuedPodInfo if a pod with the same key as the key of
+// the given "pod" is found in the map. It returns nil otherwise.
+func (u *unschedulablePods) get(pod *v1.Pod) *framework.QueuedPodInfo {
+	podKey := u.keyFunc(pod)
+	if pInfo, exists := u.podInfoMap[podKey]; exists {
+		return pInfo
+	}
+	return nil
+}
+
+// clear removes all the entries from the unschedulable podInfoMap.
+func (u *unschedulablePods) clear() {
+	u.podInfoMap = make(map[string]*framework.QueuedPodInfo)
+	u.reservationIndex = make(map[string]sets.Set[string])
+	u.pluginIndex = make(map[string]sets.Set[string])
+	if u.unschedulableRecorder != nil {
+		u.unschedulableRecorder.Clear()
+	}
+	if u.gatedRecorder != nil {
+		u.gatedRecorder.Clear()
+	}
+	if u.reservationNominatedRecorder != nil {
+		u.reservationNominatedRecorder.Clear()
+	}
+}
+
+// updateReservationIndex keeps reservationIndex consistent with the
+// NominatedReservationName carried on newPInfo, removing podID from
+// oldPInfo's reservation (if different) and inserting it into newPInfo's.
+func (u *unschedulablePods) updateReservationIndex(podID string, oldPInfo, newPInfo *framework.QueuedPodInfo) {
+	oldName := ""
+	if oldPInfo != nil {
+		oldName = oldPInfo.NominatedReservationName
+	}
+	newName := newPInfo.NominatedReservationName
+	if oldName == newName {
+		return
+	}
+	if oldName != "" {
+		u.deleteFromReservationIndex(oldName, podID)
+	}
+	if newName != "" {
+		pods, ok := u.reservationIndex[newName]
+		if !ok {
+			pods = sets.New[string]()
+			u.reservationIndex[newName] = pods
+		}
+		pods.Insert(podID)
+	}
+}
+
+func (u *unschedulablePods) deleteFromReservationIndex(reservationName, podID string) {
+	pods, ok := u.reservationIndex[reservationName]
+	if !ok {
+		return
+	}
+	pods.Delete(podID)
+	if pods.Len() == 0 {
+		delete(u.reservationIndex, reservationName)
+	}
+}
+
+// updatePluginIndex keeps pluginIndex consistent with the plugin names
+// pInfo is associated with, diffing oldPInfo's names (if any) against
+// newPInfo's and patching the index incrementally.
+func (u *unschedulablePods) updatePluginIndex(podID string, oldPInfo, newPInfo *framework.QueuedPodInfo) {
+	newNames := pluginNamesFor(newPInfo)
+	oldNames := sets.New[string]()
+	if oldPInfo != nil {
+		oldNames = pluginNamesFor(oldPInfo)
+	}
+	for name := range oldNames.Difference(newNames) {
+		u.deleteFromPluginIndex(name, podID)
+	}
+	for name := range newNames.Difference(oldNames) {
+		pods, ok := u.pluginIndex[name]
+		if !ok {
+			pods = sets.New[string]()
+			u.pluginIndex[name] = pods
+		}
+		pods.Insert(podID)
+	}
+}
+
+func (u *unschedulablePods) deleteFromPluginIndex(pluginName, podID string) {
+	pods, ok := u.pluginIndex[pluginName]
+	if !ok {
+		return
+	}
+	pods.Delete(podID)
+	if pods.Len() == 0 {
+		delete(u.pluginIndex, pluginName)
+	}
+}
+
+// podsGatedBy returns the QueuedPodInfo of every pod currently gated by
+// plugin, in O(k) where k is the number of pods indexed under plugin.
+func (u *unschedulablePods) podsGatedBy(plugin string) []*framework.QueuedPodInfo {
+	var pInfos []*framework.QueuedPodInfo
+	for podID := range u.pluginIndex[plugin] {
+		if pInfo, exists := u.podInfoMap[podID]; exists && pInfo.Gated() && pInfo.GatingPlugin == plugin {
+			pInfos = append(pInfos, pInfo)
+		}
+	}
+	return pInfos
+}
+
+// podsUnschedulableDueTo returns the QueuedPodInfo of every pod whose
+// UnschedulablePlugins contains plugin, in O(k) where k is the number of
+// pods indexed under plugin.
+func (u *unschedulablePods) podsUnschedulableDueTo(plugin string) []*framework.QueuedPodInfo {
+	var pInfos []*framework.QueuedPodInfo
+	for podID := range u.pluginIndex[plugin] {
+		if pInfo, exists := u.podInfoMap[podID]; exists && pInfo.UnschedulablePlugins.Has(plugin) {
+			pInfos = append(pInfos, pInfo)
+		}
+	}
+	return pInfos
+}
+
+// AddNominatedReservation records that pod is nominated against
+// reservationName, mirroring the bookkeeping the scheduling queue already
+// performs for NominatedNodeName. A pod can only have one active
+// reservation nomination at a time: nominating it against a new
+// reservation implicitly clears any previous reservation nomination. It
+// does not clear NominatedNodeName; a caller that needs the two to stay
+// mutually exclusive must clear the other nomination itself.
+func (u *unschedulablePods) AddNominatedReservation(pod *v1.Pod, reservationName string) {
+	if reservationName == "" {
+		return
+	}
+	podID := u.keyFunc(pod)
+	pInfo, exists := u.podInfoMap[podID]
+	if !exists || pInfo.NominatedReservationName == reservationName {
+		return
+	}
+	oldRecorder := u.recorderFor(pInfo)
+	if pInfo.NominatedReservationName != "" {
+		u.deleteFromReservationIndex(pInfo.NominatedReservationName, podID)
+	}
+	pInfo.NominatedReservationName = reservationName
+	pods, ok := u.reservationIndex[reservationName]
+	if !ok {
+		pods = sets.New[string]()
+		u.reservationIndex[reservationName] = pods
+	}
+	pods.Insert(podID)
+	if newRecorder := u.recorderFor(pInfo); oldRecorder != newRecorder {
+		oldRecorder.Dec()
+		newRecorder.Inc()
+	}
+}
+
+// DeleteNominatedReservation clears pod's reservation nomination, if it has
+// one.
+func (u *unschedulablePods) DeleteNominatedReservation(pod *v1.Pod) {
+	u.DeleteNominatedReservationIfExists(pod)
+}
+
+// DeleteNominatedReservationIfExists clears pod's reservation nomination if
+// it currently has one, and is a no-op otherwise. It is named to mirror the
+// existing node-nomination helper so callers that unconditionally clear a
+// nomination (e.g. because PostFilter returned no suggestion) don't need to
+// check first.
+func (u *unschedulablePods) DeleteNominatedReservationIfExists(pod *v1.Pod) {
+	podID := u.keyFunc(pod)
+	pInfo, exists := u.podInfoMap[podID]
+	if !exists || pInfo.NominatedReservationName == "" {
+		return
+	}
+	oldRecorder := u.recorderFor(pInfo)
+	u.deleteFromReservationIndex(pInfo.NominatedReservationName, podID)
+	pInfo.NominatedReservationName = ""
+	if newRecorder := u.recorderFor(pInfo); oldRecorder != newRecorder {
+		oldRecorder.Dec()
+		newRecorder.Inc()
+	}
+}
+
+// NominatedPodsForReservation returns the QueuedPodInfo of every pod
+// currently nominated against reservationName, in O(k) where k is the
+// number of pods nominated against that reservation.
+func (u *unschedulablePods) NominatedPodsForReservation(reservationName string) []*framework.QueuedPodInfo {
+	podIDs, ok := u.reservationIndex[reservationName]
+	if !ok {
+		return nil
+	}
+	pInfos := make([]*framework.QueuedPodInfo, 0, podIDs.Len())
+	for podID := range podIDs {
+		if pInfo, exists := u.podInfoMap[podID]; exists {
+			pInfos = append(pInfos, pInfo)
+		}
+	}
+	return pInfos
+}
+
+// newUnschedulablePods initializes a new object of unschedulablePods.
+func newUnschedulablePods(unschedulableRecorder, gatedRecorder, reservationNominatedRecorder metrics.MetricRecorder, evictedRecorder EvictedPodsRecorder, policy Policy, clock clock.Clock) *unschedulablePods {
+	return &unschedulablePods{
+		podInfoMap:                   make(map[string]*framework.QueuedPodInfo),
+		keyFunc:                      util.GetPodFullName,
+		unschedulableRecorder:        unschedulableRecorder,
+		gatedRecorder:                gatedRecorder,
+		reservationNominatedRecorder: reservationNominatedRecorder,
+		reservationIndex:             make(map[string]sets.Set[string]),
+		pluginIndex:                  make(map[string]sets.Set[string]),
+		evictedRecorder:              evictedRecorder,
+		policy:                       policy,
+		clock:                        clock,
+	}
+}
@@ -17,8 +17,10 @@ limitations under the License.
 package queue
 
 import (
+	"sort"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -27,6 +29,7 @@ import (
 	"k8s.io/kubernetes/pkg/scheduler/framework"
 	st "k8s.io/kubernetes/pkg/scheduler/testing"
 	"k8s.io/kubernetes/pkg/scheduler/util"
+	testingclock "k8s.io/utils/clock/testing"
 )
 
 type mockMetricRecorder struct {
@@ -49,6 +52,24 @@ func (m *mockMetricRecorder) Value() int64 {
 	return m.val.Load()
 }
 
+type mockEvictedRecorder struct {
+	reasons map[string]int64
+}
+
+func (m *mockEvictedRecorder) Inc(reason string) {
+	if m.reasons == nil {
+		m.reasons = map[string]int64{}
+	}
+	m.reasons[reason]++
+}
+
+// onEvictCall records a single invocation of Policy.OnEvict, so tests can
+// assert both which pod was forced out and why.
+type onEvictCall struct {
+	PodKey string
+	Reason string
+}
+
 func TestUnschedulablePods(t *testing.T) {
 	type action string
 
@@ -63,6 +84,13 @@ func TestUnschedulablePods(t *testing.T) {
 		action      action
 		pods        []*framework.QueuedPodInfo
 		expectedMap map[string]*framework.QueuedPodInfo
+		// wantGatedBy/wantUnschedulableDueTo, if non-nil, assert the full
+		// names returned by podsGatedBy/podsUnschedulableDueTo for a
+		// plugin, keyed by plugin name. These exercise the two lookup
+		// methods directly, rather than just the pluginIndex shape
+		// assertPluginIndexConsistent checks.
+		wantGatedBy            map[string][]string
+		wantUnschedulableDueTo map[string][]string
 	}
 
 	var actionToOperation = map[action]func(pInfo *framework.QueuedPodInfo, upm *unschedulablePods, gatedBefore bool){
@@ -106,9 +134,69 @@ func TestUnschedulablePods(t *testing.T) {
 		return info
 	}
 
+	makGatedByPodInfo := func(p *v1.Pod, gatingPlugin string) *framework.QueuedPodInfo {
+		info := &framework.QueuedPodInfo{
+			PodInfo:              mustNewTestPodInfo(t, p),
+			UnschedulablePlugins: sets.New[string](gatingPlugin),
+			GatingPlugin:         gatingPlugin,
+		}
+		return info
+	}
+
+	// assertPluginIndexConsistent recomputes, from upm.podInfoMap, which
+	// pods should be indexed under each plugin name and compares that
+	// against upm.pluginIndex, so every step of every test case doubles
+	// as a consistency check of the secondary index.
+	assertPluginIndexConsistent := func(t *testing.T, upm *unschedulablePods, action string) {
+		t.Helper()
+		want := map[string]sets.Set[string]{}
+		for podID, pInfo := range upm.podInfoMap {
+			for name := range pluginNamesFor(pInfo) {
+				if _, ok := want[name]; !ok {
+					want[name] = sets.New[string]()
+				}
+				want[name].Insert(podID)
+			}
+		}
+		if diff := cmp.Diff(want, upm.pluginIndex); diff != "" {
+			t.Errorf("Unexpected pluginIndex after %s (-want, +got):\n%s", action, diff)
+		}
+	}
+
+	podFullNames := func(pInfos []*framework.QueuedPodInfo) []string {
+		names := make([]string, 0, len(pInfos))
+		for _, pInfo := range pInfos {
+			names = append(names, util.GetPodFullName(pInfo.Pod))
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// assertPluginLookups exercises podsGatedBy/podsUnschedulableDueTo
+	// directly against step.wantGatedBy/wantUnschedulableDueTo, rather than
+	// only checking the pluginIndex map shape assertPluginIndexConsistent
+	// does: a regression that returns the wrong set from either method (or
+	// mixes up which backs which) would otherwise go uncaught.
+	assertPluginLookups := func(t *testing.T, upm *unschedulablePods, step step, action string) {
+		t.Helper()
+		for plugin, want := range step.wantGatedBy {
+			if diff := cmp.Diff(want, podFullNames(upm.podsGatedBy(plugin))); diff != "" {
+				t.Errorf("Unexpected podsGatedBy(%q) after %s (-want, +got):\n%s", plugin, action, diff)
+			}
+		}
+		for plugin, want := range step.wantUnschedulableDueTo {
+			if diff := cmp.Diff(want, podFullNames(upm.podsUnschedulableDueTo(plugin))); diff != "" {
+				t.Errorf("Unexpected podsUnschedulableDueTo(%q) after %s (-want, +got):\n%s", plugin, action, diff)
+			}
+		}
+	}
+
 	tests := []struct {
-		name  string
-		steps []step
+		name          string
+		policy        Policy
+		steps         []step
+		wantEvictions map[string]int64
+		wantOnEvict   []onEvictCall
 	}{
 		{
 			name: "create, update, delete subset of pods",
@@ -325,13 +413,152 @@ func TestUnschedulablePods(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "update flips GatingPlugin from one plugin to another",
+			steps: []step{
+				{
+					action: add,
+					pods:   []*framework.QueuedPodInfo{makGatedByPodInfo(pods[0], "pluginA"), makGatedByPodInfo(pods[1], "pluginB")},
+					expectedMap: map[string]*framework.QueuedPodInfo{
+						util.GetPodFullName(pods[0]): makGatedByPodInfo(pods[0], "pluginA"),
+						util.GetPodFullName(pods[1]): makGatedByPodInfo(pods[1], "pluginB"),
+					},
+					wantGatedBy: map[string][]string{
+						"pluginA": {util.GetPodFullName(pods[0])},
+						"pluginB": {util.GetPodFullName(pods[1])},
+					},
+					wantUnschedulableDueTo: map[string][]string{
+						"pluginA": {util.GetPodFullName(pods[0])},
+						"pluginB": {util.GetPodFullName(pods[1])},
+					},
+				},
+				{
+					action: update,
+					pods:   []*framework.QueuedPodInfo{makGatedByPodInfo(pods[0], "pluginB")},
+					expectedMap: map[string]*framework.QueuedPodInfo{
+						util.GetPodFullName(pods[0]): makGatedByPodInfo(pods[0], "pluginB"),
+						util.GetPodFullName(pods[1]): makGatedByPodInfo(pods[1], "pluginB"),
+					},
+					// pod0 flipped from pluginA to pluginB: pluginA should
+					// have no pods left under either lookup, and pluginB
+					// should now gate/own both pods.
+					wantGatedBy: map[string][]string{
+						"pluginA": {},
+						"pluginB": {util.GetPodFullName(pods[0]), util.GetPodFullName(pods[1])},
+					},
+					wantUnschedulableDueTo: map[string][]string{
+						"pluginA": {},
+						"pluginB": {util.GetPodFullName(pods[0]), util.GetPodFullName(pods[1])},
+					},
+				},
+				{
+					action: delete,
+					pods:   []*framework.QueuedPodInfo{makGatedByPodInfo(pods[0], "pluginB")},
+					expectedMap: map[string]*framework.QueuedPodInfo{
+						util.GetPodFullName(pods[1]): makGatedByPodInfo(pods[1], "pluginB"),
+					},
+				},
+			},
+		},
+		{
+			name:   "adding MaxSize+1 pods evicts the oldest entry",
+			policy: Policy{MaxSize: 5, EvictionStrategy: EvictionOldestFirst},
+			steps: []step{
+				{
+					action: add,
+					pods: []*framework.QueuedPodInfo{
+						makPodInfo(pods[0], false),
+						makPodInfo(pods[1], false),
+						makPodInfo(pods[2], false),
+						makPodInfo(pods[3], false),
+						makPodInfo(pods[4], true),
+						makPodInfo(pods[5], true),
+					},
+					expectedMap: map[string]*framework.QueuedPodInfo{
+						util.GetPodFullName(pods[1]): makPodInfo(pods[1], false),
+						util.GetPodFullName(pods[2]): makPodInfo(pods[2], false),
+						util.GetPodFullName(pods[3]): makPodInfo(pods[3], false),
+						util.GetPodFullName(pods[4]): makPodInfo(pods[4], true),
+						util.GetPodFullName(pods[5]): makPodInfo(pods[5], true),
+					},
+				},
+			},
+			wantEvictions: map[string]int64{string(EvictionOldestFirst): 1},
+			wantOnEvict:   []onEvictCall{{PodKey: util.GetPodFullName(pods[0]), Reason: string(EvictionOldestFirst)}},
+		},
+		{
+			name:   "EvictionReject drops and reports the incoming pod once at capacity",
+			policy: Policy{MaxSize: 2, EvictionStrategy: EvictionReject},
+			steps: []step{
+				{
+					action: add,
+					pods:   []*framework.QueuedPodInfo{makPodInfo(pods[0], false), makPodInfo(pods[1], false)},
+					expectedMap: map[string]*framework.QueuedPodInfo{
+						util.GetPodFullName(pods[0]): makPodInfo(pods[0], false),
+						util.GetPodFullName(pods[1]): makPodInfo(pods[1], false),
+					},
+				},
+				{
+					action: add,
+					pods:   []*framework.QueuedPodInfo{makPodInfo(pods[2], false)},
+					expectedMap: map[string]*framework.QueuedPodInfo{
+						util.GetPodFullName(pods[0]): makPodInfo(pods[0], false),
+						util.GetPodFullName(pods[1]): makPodInfo(pods[1], false),
+					},
+				},
+			},
+			wantEvictions: map[string]int64{string(EvictionReject): 1},
+			wantOnEvict:   []onEvictCall{{PodKey: util.GetPodFullName(pods[2]), Reason: string(EvictionReject)}},
+		},
+		{
+			name:   "EvictionLeastRecentlyRequeued evicts the entry with the lowest RequeueCount",
+			policy: Policy{MaxSize: 2, EvictionStrategy: EvictionLeastRecentlyRequeued},
+			steps: []step{
+				{
+					action: add,
+					pods:   []*framework.QueuedPodInfo{makPodInfo(pods[0], false), makPodInfo(pods[1], false)},
+					expectedMap: map[string]*framework.QueuedPodInfo{
+						util.GetPodFullName(pods[0]): makPodInfo(pods[0], false),
+						util.GetPodFullName(pods[1]): makPodInfo(pods[1], false),
+					},
+				},
+				{
+					// Requeuing pod1 bumps its RequeueCount, so it should
+					// survive the next eviction in pod0's place.
+					action: update,
+					pods:   []*framework.QueuedPodInfo{makPodInfo(pods[1], false)},
+					expectedMap: map[string]*framework.QueuedPodInfo{
+						util.GetPodFullName(pods[0]): makPodInfo(pods[0], false),
+						util.GetPodFullName(pods[1]): makPodInfo(pods[1], false),
+					},
+				},
+				{
+					action: add,
+					pods:   []*framework.QueuedPodInfo{makPodInfo(pods[2], false)},
+					expectedMap: map[string]*framework.QueuedPodInfo{
+						util.GetPodFullName(pods[1]): makPodInfo(pods[1], false),
+						util.GetPodFullName(pods[2]): makPodInfo(pods[2], false),
+					},
+				},
+			},
+			wantEvictions: map[string]int64{string(EvictionLeastRecentlyRequeued): 1},
+			wantOnEvict:   []onEvictCall{{PodKey: util.GetPodFullName(pods[0]), Reason: string(EvictionLeastRecentlyRequeued)}},
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			unschedulableRecorder := &mockMetricRecorder{}
 			gatedRecorder := &mockMetricRecorder{}
-			upm := newUnschedulablePods(unschedulableRecorder, gatedRecorder)
+			reservationNominatedRecorder := &mockMetricRecorder{}
+			evictedRecorder := &mockEvictedRecorder{}
+			fakeClock := testingclock.NewFakeClock(time.Now())
+			var onEvictCalls []onEvictCall
+			policy := test.policy
+			policy.OnEvict = func(pInfo *framework.QueuedPodInfo, reason string) {
+				onEvictCalls = append(onEvictCalls, onEvictCall{PodKey: util.GetPodFullName(pInfo.Pod), Reason: reason})
+			}
+			upm := newUnschedulablePods(unschedulableRecorder, gatedRecorder, reservationNominatedRecorder, evictedRecorder, policy, fakeClock)
 			assertMetrics := func(expectedMapAfterAction map[string]*framework.QueuedPodInfo, action string) {
 				t.Helper()
 
@@ -350,18 +577,38 @@ func TestUnschedulablePods(t *testing.T) {
 				if gatedRecorder.Value() != int64(expectedGatedMetric) {
 					t.Errorf("Expected gated metric to be %d, but got %d after %s", expectedGatedMetric, gatedRecorder.Value(), action)
 				}
+				if reservationNominatedRecorder.Value() != 0 {
+					t.Errorf("Expected reservation-nominated metric to be 0, but got %d after %s", reservationNominatedRecorder.Value(), action)
+				}
 			}
 
 			for _, step := range test.steps {
 				op := actionToOperation[step.action]
 				for _, p := range step.pods {
+					// Advance the clock before every operation so
+					// LastAddedTimestamp strictly increases in call
+					// order, making eviction ordering deterministic
+					// instead of depending on real timer resolution.
+					fakeClock.Step(time.Second)
 					op(p, upm, gated(p.Pod, upm))
 				}
-				if diff := cmp.Diff(step.expectedMap, upm.podInfoMap, cmpopts.IgnoreUnexported(framework.PodInfo{})); diff != "" {
+				if diff := cmp.Diff(step.expectedMap, upm.podInfoMap,
+					cmpopts.IgnoreUnexported(framework.PodInfo{}),
+					cmpopts.IgnoreFields(framework.QueuedPodInfo{}, "LastAddedTimestamp", "RequeueCount"),
+				); diff != "" {
 					t.Errorf("Unexpected map after %s pods(-want, +got):\n%s", step.action, diff)
 				}
 
 				assertMetrics(step.expectedMap, string(step.action))
+				assertPluginIndexConsistent(t, upm, string(step.action))
+				assertPluginLookups(t, upm, step, string(step.action))
+			}
+
+			if diff := cmp.Diff(test.wantEvictions, evictedRecorder.reasons); diff != "" {
+				t.Errorf("Unexpected evictions recorded (-want, +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.wantOnEvict, onEvictCalls); diff != "" {
+				t.Errorf("Unexpected OnEvict calls (-want, +got):\n%s", diff)
 			}
 
 			upm.clear()
@@ -369,6 +616,115 @@ func TestUnschedulablePods(t *testing.T) {
 				t.Errorf("Expected the map to be empty, but has %v elements.", len(upm.podInfoMap))
 			}
 			assertMetrics(map[string]*framework.QueuedPodInfo{}, string(clear))
+			if len(upm.pluginIndex) != 0 {
+				t.Errorf("Expected pluginIndex to be empty, but has %v elements.", len(upm.pluginIndex))
+			}
 		})
 	}
 }
+
+// TestUnschedulablePodsReservationNomination exercises the
+// reservation-nomination bookkeeping AddNominatedReservation,
+// DeleteNominatedReservation(IfExists), and NominatedPodsForReservation
+// add on top of the plain add/update/delete operations TestUnschedulablePods
+// covers: nominating, re-nominating against a different reservation,
+// clearing, and deleting a nominated pod all need to keep reservationIndex
+// and reservationNominatedRecorder consistent with podInfoMap.
+func TestUnschedulablePodsReservationNomination(t *testing.T) {
+	unschedulableRecorder := &mockMetricRecorder{}
+	gatedRecorder := &mockMetricRecorder{}
+	reservationNominatedRecorder := &mockMetricRecorder{}
+	evictedRecorder := &mockEvictedRecorder{}
+	fakeClock := testingclock.NewFakeClock(time.Now())
+	upm := newUnschedulablePods(unschedulableRecorder, gatedRecorder, reservationNominatedRecorder, evictedRecorder, Policy{}, fakeClock)
+
+	pod0 := st.MakePod().Name("p0").Namespace("ns1").Obj()
+	pod1 := st.MakePod().Name("p1").Namespace("ns1").Obj()
+
+	assertReservationIndexConsistent := func(action string) {
+		t.Helper()
+		want := map[string]sets.Set[string]{}
+		for podID, pInfo := range upm.podInfoMap {
+			if pInfo.NominatedReservationName == "" {
+				continue
+			}
+			if _, ok := want[pInfo.NominatedReservationName]; !ok {
+				want[pInfo.NominatedReservationName] = sets.New[string]()
+			}
+			want[pInfo.NominatedReservationName].Insert(podID)
+		}
+		if diff := cmp.Diff(want, upm.reservationIndex); diff != "" {
+			t.Errorf("Unexpected reservationIndex after %s (-want, +got):\n%s", action, diff)
+		}
+	}
+
+	newInfo := func(p *v1.Pod) *framework.QueuedPodInfo {
+		return &framework.QueuedPodInfo{PodInfo: mustNewTestPodInfo(t, p), UnschedulablePlugins: sets.New[string]()}
+	}
+
+	upm.addOrUpdate(newInfo(pod0), false, framework.EventUnscheduledPodAdd.Label())
+	upm.addOrUpdate(newInfo(pod1), false, framework.EventUnscheduledPodAdd.Label())
+	assertReservationIndexConsistent("add")
+	if reservationNominatedRecorder.Value() != 0 || unschedulableRecorder.Value() != 2 {
+		t.Fatalf("Expected 2 unschedulable pods and 0 reservation-nominated pods, got %d/%d", unschedulableRecorder.Value(), reservationNominatedRecorder.Value())
+	}
+
+	upm.AddNominatedReservation(pod0, "resA")
+	assertReservationIndexConsistent("nominate pod0 -> resA")
+	if got := upm.get(pod0).NominatedReservationName; got != "resA" {
+		t.Errorf("Expected pod0 to be nominated against resA, got %q", got)
+	}
+	if reservationNominatedRecorder.Value() != 1 || unschedulableRecorder.Value() != 1 {
+		t.Errorf("Expected 1 reservation-nominated pod and 1 unschedulable pod, got %d/%d", reservationNominatedRecorder.Value(), unschedulableRecorder.Value())
+	}
+	if got := upm.NominatedPodsForReservation("resA"); len(got) != 1 || got[0].Pod.Name != "p0" {
+		t.Errorf("Expected NominatedPodsForReservation(resA) to return [p0], got %v", got)
+	}
+
+	// Re-nominating pod0 against a different reservation should move it,
+	// not leave a stale entry behind in resA's set.
+	upm.AddNominatedReservation(pod0, "resB")
+	assertReservationIndexConsistent("re-nominate pod0 -> resB")
+	if got := upm.NominatedPodsForReservation("resA"); len(got) != 0 {
+		t.Errorf("Expected resA to have no nominated pods after re-nomination, got %v", got)
+	}
+	if got := upm.NominatedPodsForReservation("resB"); len(got) != 1 || got[0].Pod.Name != "p0" {
+		t.Errorf("Expected NominatedPodsForReservation(resB) to return [p0], got %v", got)
+	}
+	if reservationNominatedRecorder.Value() != 1 {
+		t.Errorf("Expected reservation-nominated metric to stay at 1 across re-nomination, got %d", reservationNominatedRecorder.Value())
+	}
+
+	upm.AddNominatedReservation(pod1, "resB")
+	assertReservationIndexConsistent("nominate pod1 -> resB")
+	if got := upm.NominatedPodsForReservation("resB"); len(got) != 2 {
+		t.Errorf("Expected NominatedPodsForReservation(resB) to return 2 pods, got %d", len(got))
+	}
+	if reservationNominatedRecorder.Value() != 2 || unschedulableRecorder.Value() != 0 {
+		t.Errorf("Expected 2 reservation-nominated pods and 0 unschedulable pods, got %d/%d", reservationNominatedRecorder.Value(), unschedulableRecorder.Value())
+	}
+
+	upm.DeleteNominatedReservationIfExists(pod0)
+	assertReservationIndexConsistent("clear pod0's nomination")
+	if got := upm.get(pod0).NominatedReservationName; got != "" {
+		t.Errorf("Expected pod0's nomination to be cleared, got %q", got)
+	}
+	if reservationNominatedRecorder.Value() != 1 || unschedulableRecorder.Value() != 1 {
+		t.Errorf("Expected 1 reservation-nominated pod and 1 unschedulable pod after clearing pod0, got %d/%d", reservationNominatedRecorder.Value(), unschedulableRecorder.Value())
+	}
+
+	// Clearing a pod with no nomination, or one the map doesn't know
+	// about, is a no-op.
+	upm.DeleteNominatedReservationIfExists(pod0)
+	upm.DeleteNominatedReservation(st.MakePod().Name("ghost").Namespace("ns1").Obj())
+	assertReservationIndexConsistent("clear pod0's nomination again (no-op)")
+
+	upm.delete(pod1, false)
+	assertReservationIndexConsistent("delete nominated pod1")
+	if reservationNominatedRecorder.Value() != 0 {
+		t.Errorf("Expected reservation-nominated metric to be 0 after deleting the last nominated pod, got %d", reservationNominatedRecorder.Value())
+	}
+	if len(upm.reservationIndex) != 0 {
+		t.Errorf("Expected reservationIndex to be empty, got %v", upm.reservationIndex)
+	}
+}
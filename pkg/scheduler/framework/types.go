@@ -0,0 +1,98 @@
+/*
+Copyright 2025 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// AffinityTerm is a processed version of v1.PodAffinityTerm.
+type AffinityTerm struct {
+	Namespaces        sets.Set[string]
+	Selector          labels.Selector
+	TopologyKey       string
+	NamespaceSelector labels.Selector
+}
+
+// WeightedAffinityTerm is a "processed" representation of v1.WeightedAffinityTerm.
+type WeightedAffinityTerm struct {
+	AffinityTerm
+	Weight int32
+}
+
+// PodInfo is a wrapper to a Pod with additional pre-computed information to
+// accelerate predicates. This information is typically immutable (e.g.,
+// pre-processed affinity selectors).
+type PodInfo struct {
+	Pod                        *v1.Pod
+	requiredAffinityTerms      []AffinityTerm
+	requiredAntiAffinityTerms  []AffinityTerm
+	preferredAffinityTerms     []WeightedAffinityTerm
+	preferredAntiAffinityTerms []WeightedAffinityTerm
+}
+
+// QueuedPodInfo is a Pod wrapper with additional information related to
+// the pod's status in the scheduling queue, such as the timestamp when
+// it's added to the queue.
+type QueuedPodInfo struct {
+	*PodInfo
+	// Timestamp is the time the pod is added to the queue.
+	Timestamp time.Time
+	// Attempts is the number of schedule attempts before this pod is
+	// successfully scheduled.
+	Attempts int
+	// InitialAttemptTimestamp is the timestamp when the pod is added to the
+	// queue for the first time. The pod may be added to the queue multiple
+	// times before it's successfully scheduled.
+	InitialAttemptTimestamp *time.Time
+	// UnschedulablePlugins contains the names of plugins that previously
+	// returned Unschedulable/UnschedulableAndUnresolvable for this pod,
+	// and therefore identify the cluster events that should cause this
+	// pod to be reconsidered.
+	UnschedulablePlugins sets.Set[string]
+	// PendingPlugins contains the names of plugins that previously
+	// returned Pending for this pod.
+	PendingPlugins sets.Set[string]
+	// GatingPlugin is the name of the PreEnqueue plugin that gates this
+	// pod, or the empty string if the pod is not gated.
+	GatingPlugin string
+	// NominatedNodeName is the node this pod is nominated to run on, as
+	// determined by the last PostFilter run for it.
+	NominatedNodeName string
+	// NominatedReservationName is the Reservation this pod is nominated
+	// against, as determined by the last PostFilter run for it. A pod is
+	// expected to have at most one active nomination at a time, but this
+	// type does not itself enforce mutual exclusivity with
+	// NominatedNodeName: callers that nominate a pod against one are
+	// responsible for clearing the other.
+	NominatedReservationName string
+	// LastAddedTimestamp is the last time this pod was added to
+	// unschedulablePods, updated on every addOrUpdate that stores the pod.
+	LastAddedTimestamp time.Time
+	// RequeueCount is the number of times this pod has been re-added to
+	// unschedulablePods after its first addition.
+	RequeueCount int
+}
+
+// Gated returns true if the pod is gated by a PreEnqueue plugin.
+func (pqi *QueuedPodInfo) Gated() bool {
+	return pqi.GatingPlugin != ""
+}